@@ -28,16 +28,16 @@ func main() {
 
 	// Add a host to the inventory
 	// As of now, it's hardcoded to a docker container on localhost
-	m, err := rmt.New("localhost:2222", "gossh", "gosshpwd", ssh.InsecureIgnoreHostKey(), ssh.Password("gosshpwd"))
+	m, err := rmt.New("localhost:2222", "gossh", "gosshpwd", ssh.InsecureIgnoreHostKey(), []ssh.AuthMethod{ssh.Password("gosshpwd")})
 	if err != nil {
 		fmt.Printf("could not get new host %v: %v\n", m, err)
 		return
 	}
 
-	inventory.Add(m)
+	inventory.Add(&gossh.Host{Name: "gossh-docker", Remote: m})
 
-	// TODO - add inventory from files, e.g.:
-	// gossh.NewInventoryFromFile("./inventory.json")
+	// Larger setups can load hosts, groups and variables from a file instead, e.g.:
+	// inventory, err = gossh.NewInventoryFromFile("./inventory.yml")
 
 	bootstrap := base.Multi{}
 
@@ -80,12 +80,11 @@ func main() {
 		},
 	})
 
-	for _, m := range inventory {
-		log.Println("doing host", m)
-		_, err = m.Apply("bootstrap", bootstrap)
-		if err != nil {
-			fmt.Println("apply of bootstrap gone wrong", err)
-		}
+	report := inventory.Apply("bootstrap", bootstrap, gossh.WithConcurrency(10))
+
+	log.Println("bootstrap report:\n" + report.Text())
+	if err := report.WriteJSON(os.Stdout); err != nil {
+		fmt.Println("could not write report as json", err)
 	}
 
 }
@@ -0,0 +1,269 @@
+package gossh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/krilor/gossh/rmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// Host is a single inventory entry: a connected Remote plus the group membership and
+// variables it was loaded with.
+type Host struct {
+	Name string
+	rmt.Remote
+	Vars   map[string]interface{}
+	Groups []string
+}
+
+// Var returns the inventory variable named key that was merged onto h from its
+// groups and host_vars, and whether it was set at all. Rules read per-host
+// configuration (e.g. base.Meta's EnsureFunc) through this accessor rather than
+// Vars directly, so a Target implementation can satisfy var lookups without
+// exposing the underlying map.
+func (h *Host) Var(key string) (interface{}, bool) {
+	v, ok := h.Vars[key]
+	return v, ok
+}
+
+// Inventory is a flat collection of Hosts, typically built from an inventory file
+// with NewInventoryFromFile or assembled by hand with Add.
+type Inventory []*Host
+
+// Add appends a host to the inventory.
+func (inv *Inventory) Add(h *Host) {
+	*inv = append(*inv, h)
+}
+
+// Group returns the subset of the inventory whose Groups include name.
+func (inv Inventory) Group(name string) Inventory {
+	out := Inventory{}
+	for _, h := range inv {
+		for _, g := range h.Groups {
+			if g == name {
+				out = append(out, h)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Limit filters the inventory using an Ansible-style host pattern: a colon-separated
+// list of terms that are unioned together, where a term prefixed with "!" excludes
+// hosts instead of including them. Each term may be a comma-separated list of host
+// or group names, e.g. "host1,web:!staging" selects host1 and group web, minus
+// anything in group staging.
+func (inv Inventory) Limit(pattern string) Inventory {
+
+	include := map[string]bool{}
+	exclude := map[string]bool{}
+
+	for _, term := range strings.Split(pattern, ":") {
+		neg := strings.HasPrefix(term, "!")
+		term = strings.TrimPrefix(term, "!")
+
+		for _, name := range strings.Split(term, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if neg {
+				exclude[name] = true
+			} else {
+				include[name] = true
+			}
+		}
+	}
+
+	out := Inventory{}
+	for _, h := range inv {
+		if matchesAny(h, include) && !matchesAny(h, exclude) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// matchesAny reports whether h's name or any of its groups is a key in names.
+func matchesAny(h *Host, names map[string]bool) bool {
+	if names[h.Name] {
+		return true
+	}
+	for _, g := range h.Groups {
+		if names[g] {
+			return true
+		}
+	}
+	return false
+}
+
+// invGroup is the on-disk shape of a single inventory group, modelled after
+// Ansible's grouped inventory format: a group has its own hosts and variables, plus
+// nested child groups that inherit the parent's variables.
+type invGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts" json:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars" json:"vars"`
+	Children map[string]invGroup               `yaml:"children" json:"children"`
+}
+
+// NewInventoryFromFile loads an Ansible-style inventory from a YAML or JSON file
+// (the format is chosen from the file extension) and connects a rmt.Remote for every
+// host it defines. Per-host connection details are read from the usual Ansible
+// variables: ansible_host, ansible_port, ansible_user,
+// ansible_ssh_private_key_file and ansible_become_pass.
+func NewInventoryFromFile(path string) (Inventory, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read inventory file %s", path)
+	}
+
+	raw := map[string]invGroup{}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".json":
+		err = json.Unmarshal(b, &raw)
+	default:
+		return nil, errors.Errorf("unsupported inventory file extension %q", ext)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse inventory file %s", path)
+	}
+
+	inv := Inventory{}
+	byname := map[string]*Host{}
+
+	for _, name := range sortedGroupKeys(raw) {
+		if err := addGroup(&inv, byname, name, raw[name], nil); err != nil {
+			return nil, errors.Wrapf(err, "group %s", name)
+		}
+	}
+
+	return inv, nil
+}
+
+// addGroup walks an invGroup, merging inherited variables into its hosts and
+// children, and adds every host it finds to inv (or merges into the existing Host if
+// the same host appears in more than one group).
+func addGroup(inv *Inventory, byname map[string]*Host, name string, g invGroup, inherited map[string]interface{}) error {
+
+	vars := mergeVars(inherited, g.Vars)
+
+	for _, hostname := range sortedHostKeys(g.Hosts) {
+		merged := mergeVars(vars, g.Hosts[hostname])
+
+		h, ok := byname[hostname]
+		if !ok {
+			r, err := hostToRemote(hostname, merged)
+			if err != nil {
+				return errors.Wrapf(err, "host %s", hostname)
+			}
+			h = &Host{Name: hostname, Remote: r, Vars: merged}
+			byname[hostname] = h
+			inv.Add(h)
+		} else {
+			for k, v := range merged {
+				h.Vars[k] = v
+			}
+		}
+
+		h.Groups = appendUnique(h.Groups, name)
+	}
+
+	for _, childname := range sortedGroupKeys(g.Children) {
+		if err := addGroup(inv, byname, childname, g.Children[childname], vars); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortedGroupKeys returns m's keys in sorted order, so that a group's children are
+// always walked in the same order regardless of map iteration order.
+func sortedGroupKeys(m map[string]invGroup) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedHostKeys returns m's keys in sorted order, so that hosts shared between
+// groups are always merged in the same order regardless of map iteration order.
+func sortedHostKeys(m map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mergeVars shallow-merges override on top of base, without mutating either.
+func mergeVars(base, override map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		out[k] = v
+	}
+	return out
+}
+
+// appendUnique appends name to names if it is not already present.
+func appendUnique(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
+
+// hostToRemote connects a rmt.Remote for hostname using its Ansible-style connection
+// variables.
+func hostToRemote(hostname string, vars map[string]interface{}) (rmt.Remote, error) {
+
+	addr := fmt.Sprintf("%s:%s", varString(vars, "ansible_host", hostname), varString(vars, "ansible_port", "22"))
+	user := varString(vars, "ansible_user", "root")
+	sudopass := varString(vars, "ansible_become_pass", "")
+
+	var auths []ssh.AuthMethod
+	if keyfile := varString(vars, "ansible_ssh_private_key_file", ""); keyfile != "" {
+		key, err := ioutil.ReadFile(keyfile)
+		if err != nil {
+			return rmt.Remote{}, errors.Wrapf(err, "unable to read private key %s", keyfile)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return rmt.Remote{}, errors.Wrapf(err, "unable to parse private key %s", keyfile)
+		}
+		auths = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	} else {
+		auths = []ssh.AuthMethod{rmt.AgentAuths()}
+	}
+
+	return rmt.New(addr, user, sudopass, ssh.InsecureIgnoreHostKey(), auths)
+}
+
+// varString reads key out of vars as a string, falling back to def if it is unset.
+func varString(vars map[string]interface{}, key, def string) string {
+	v, ok := vars[key]
+	if !ok {
+		return def
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,123 @@
+package gossh
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeVars(t *testing.T) {
+
+	var tests = []struct {
+		name     string
+		base     map[string]interface{}
+		override map[string]interface{}
+		expect   map[string]interface{}
+	}{
+		{
+			name:     "nil base and override",
+			base:     nil,
+			override: nil,
+			expect:   map[string]interface{}{},
+		},
+		{
+			name:     "override adds a new key",
+			base:     map[string]interface{}{"ansible_user": "root"},
+			override: map[string]interface{}{"ansible_port": "2222"},
+			expect:   map[string]interface{}{"ansible_user": "root", "ansible_port": "2222"},
+		},
+		{
+			name:     "override replaces a shared key",
+			base:     map[string]interface{}{"ansible_user": "root"},
+			override: map[string]interface{}{"ansible_user": "deploy"},
+			expect:   map[string]interface{}{"ansible_user": "deploy"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			got := mergeVars(test.base, test.override)
+
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("value: got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+
+	base := map[string]interface{}{"ansible_user": "root"}
+	override := map[string]interface{}{"ansible_port": "2222"}
+	mergeVars(base, override)
+	if len(base) != 1 || len(override) != 1 {
+		t.Errorf("mergeVars mutated its inputs: base=%v override=%v", base, override)
+	}
+}
+
+// TestHostVar checks that the group and host_vars merge addGroup performs ends up
+// observable by a rule through Host.Var, which is how base.Meta's EnsureFunc and
+// similar rules are expected to read per-host configuration.
+func TestHostVar(t *testing.T) {
+
+	groupVars := map[string]interface{}{"ansible_user": "deploy", "env": "prod"}
+	hostVars := map[string]interface{}{"env": "staging"}
+
+	h := &Host{Name: "web1", Vars: mergeVars(groupVars, hostVars)}
+
+	rule := func(t *Host) (interface{}, bool) { return t.Var("env") }
+
+	if v, ok := rule(h); !ok || v != "staging" {
+		t.Errorf("Var(\"env\") = %v, %v - want \"staging\", true", v, ok)
+	}
+
+	if v, ok := h.Var("ansible_user"); !ok || v != "deploy" {
+		t.Errorf(`Var("ansible_user") = %v, %v - want "deploy", true`, v, ok)
+	}
+
+	if _, ok := h.Var("missing"); ok {
+		t.Errorf("Var(\"missing\") reported ok=true for an unset key")
+	}
+}
+
+func TestInventoryLimit(t *testing.T) {
+
+	web1 := &Host{Name: "web1", Groups: []string{"web", "staging"}}
+	web2 := &Host{Name: "web2", Groups: []string{"web", "prod"}}
+	db1 := &Host{Name: "db1", Groups: []string{"db", "prod"}}
+
+	inv := Inventory{web1, web2, db1}
+
+	var tests = []struct {
+		pattern string
+		expect  []string
+	}{
+		{"web1", []string{"web1"}},
+		{"web", []string{"web1", "web2"}},
+		{"web,db", []string{"web1", "web2", "db1"}},
+		{"web:!staging", []string{"web2"}},
+		{"all:!prod", []string{"web1"}},
+	}
+
+	// "all" isn't a magic group name in Limit, so alias it to every host for the last case.
+	for _, h := range inv {
+		h.Groups = append(h.Groups, "all")
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+
+			got := namesOf(inv.Limit(test.pattern))
+
+			if !reflect.DeepEqual(got, test.expect) {
+				t.Errorf("value: got %v - expect %v", got, test.expect)
+			}
+		})
+	}
+}
+
+// namesOf returns the Names of an Inventory, in order, for easy comparison in tests.
+func namesOf(inv Inventory) []string {
+	names := []string{}
+	for _, h := range inv {
+		names = append(names, h.Name)
+	}
+	return names
+}
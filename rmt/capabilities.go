@@ -0,0 +1,85 @@
+package rmt
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Capabilities describes what a Remote's connecting user can do with respect to
+// privilege escalation, as determined by probe.
+type Capabilities struct {
+	// Root reports whether the connecting user is already uid 0.
+	Root bool
+	// NopasswdSudo reports whether sudo can be used without supplying a password.
+	NopasswdSudo bool
+	// PasswordSudo reports whether sudo works with the configured sudopass.
+	PasswordSudo bool
+}
+
+// ErrSudoPasswordRequired is returned by run when sudo is requested, sudo requires a
+// password and none was configured on the Remote.
+var ErrSudoPasswordRequired = errors.New("sudo requires a password, but none was configured")
+
+// ErrSudoUnavailable is returned by run when sudo is requested but the connecting
+// user can use sudo neither without a password nor with the configured sudopass.
+var ErrSudoUnavailable = errors.New("user has no usable sudo access")
+
+// Capabilities returns the privilege escalation capabilities discovered for this
+// Remote's connecting user by probe in New or NewChain.
+func (r Remote) Capabilities() Capabilities {
+	return r.caps
+}
+
+// probe determines connuser's privilege escalation capabilities by running whoami,
+// "sudo -n whoami" and "sudo -S whoami" and records the result on r.caps, so that run
+// knows up front whether it can skip feeding a sudo password. It is called once from
+// New or NewChain, before Capabilities are available to callers.
+//
+// probe never fails the connection on account of sudo being unusable - plenty of
+// Remotes are only ever used without sudo - it merely records what it found. run is
+// the one that turns an unusable sudo into ErrSudoPasswordRequired/ErrSudoUnavailable,
+// and only when sudo is actually requested.
+func (r *Remote) probe() error {
+
+	who, err := r.exec("whoami", "")
+	if err != nil {
+		return errors.Wrap(err, "unable to determine connecting user")
+	}
+
+	if strings.TrimSpace(who) == "root" {
+		r.caps = Capabilities{Root: true}
+		return nil
+	}
+
+	caps := Capabilities{}
+
+	if out, err := r.exec("sudo -n whoami", ""); err == nil && strings.TrimSpace(out) == "root" {
+		caps.NopasswdSudo = true
+	}
+
+	if out, err := r.exec("sudo -S whoami", r.sudopass); err == nil && strings.TrimSpace(out) == "root" {
+		caps.PasswordSudo = true
+	}
+
+	r.caps = caps
+	return nil
+}
+
+// exec runs a one-off command over a fresh session, feeding stdin if given, and
+// returns its stdout. It is used by probe, before r.caps is populated and run's sudo
+// prompt handling can be relied upon.
+func (r *Remote) exec(cmd string, stdin string) (string, error) {
+	session, err := r.newSession()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create new session")
+	}
+	defer session.Close()
+
+	if stdin != "" {
+		session.Stdin = strings.NewReader(stdin + "\n")
+	}
+
+	out, err := session.Output(cmd)
+	return string(out), err
+}
@@ -0,0 +1,236 @@
+package rmt
+
+import (
+	stderrors "errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultKeepalive is the interval used for keepalive requests unless overridden
+// with WithKeepalive.
+const defaultKeepalive = 30 * time.Second
+
+// defaultMaxSessions bounds concurrent sessions per Remote unless overridden with
+// WithMaxSessions, matching OpenSSH's own default MaxSessions.
+const defaultMaxSessions = 10
+
+// connState holds everything about a Remote's live connection that must be shared
+// across every copy of Remote - including ones returned by As - so that a reconnect
+// performed through one copy is visible to all of them.
+type connState struct {
+	mu     sync.Mutex
+	client *ssh.Client
+	// hops holds the intermediate *ssh.Client connections opened by dialChain for a
+	// Remote created by NewChain with more than one bastion, so they can be closed
+	// alongside client.
+	hops []*ssh.Client
+	sftp map[string]*sftp.Client
+	stop chan struct{}
+	// refs counts the live Remotes sharing this connState: the one returned by New
+	// (or NewVia/NewChain), plus one for every other chain that was later dialed
+	// through it as a bastion. Close only tears down the underlying connection once
+	// refs drops to zero, so closing one target doesn't pull the rug out from under
+	// a bastion still in use by other targets.
+	refs   int
+	closed bool
+}
+
+// Option configures optional connection-management behaviour of a Remote created by
+// New.
+type Option func(*Remote)
+
+// WithKeepalive sets the interval at which the Remote sends keepalive requests to
+// the SSH server, used to detect a silently dropped connection so it can be redialed
+// before the next session is needed. The default is 30 seconds; pass 0 to disable
+// keepalives.
+func WithKeepalive(interval time.Duration) Option {
+	return func(r *Remote) {
+		r.keepalive = interval
+	}
+}
+
+// WithMaxSessions bounds the number of concurrent command sessions (run calls and
+// probe's commands) held open against a Remote at any one time. The sftp sessions
+// used by put and the file methods are not counted. The default is 10, OpenSSH's own
+// default MaxSessions. Pass 0 to leave sessions unbounded.
+func WithMaxSessions(n int) Option {
+	return func(r *Remote) {
+		if n <= 0 {
+			r.sessions = nil
+			return
+		}
+		r.sessions = make(chan struct{}, n)
+	}
+}
+
+// ReconnectPolicy controls how a Remote redials after losing its connection.
+type ReconnectPolicy struct {
+	// MaxAttempts is the number of redial attempts before giving up. 0 means retry
+	// indefinitely.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponential backoff applied between retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultReconnectPolicy retries indefinitely, backing off exponentially from 1s up
+// to 30s between attempts.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+// WithReconnectPolicy overrides the backoff used when redialing a dropped
+// connection.
+func WithReconnectPolicy(p ReconnectPolicy) Option {
+	return func(r *Remote) {
+		r.reconnect = p
+	}
+}
+
+// remoteSession wraps a *ssh.Session so that closing it also releases the session
+// slot acquired from Remote.sessions.
+type remoteSession struct {
+	*ssh.Session
+	release func()
+}
+
+func (s *remoteSession) Close() error {
+	defer s.release()
+	return s.Session.Close()
+}
+
+// newSession opens a new SSH session against the Remote, bounding concurrent
+// sessions via r.sessions and transparently redialing if the underlying connection
+// has dropped.
+func (r Remote) newSession() (*remoteSession, error) {
+
+	release := func() {}
+	if r.sessions != nil {
+		r.sessions <- struct{}{}
+		release = func() { <-r.sessions }
+	}
+
+	r.cs.mu.Lock()
+	client := r.cs.client
+	r.cs.mu.Unlock()
+
+	session, err := client.NewSession()
+	if err == nil {
+		return &remoteSession{Session: session, release: release}, nil
+	}
+
+	if !isDisconnect(err) {
+		release()
+		return nil, errors.Wrap(err, "unable to create new session")
+	}
+
+	if err := r.redial(); err != nil {
+		release()
+		return nil, err
+	}
+
+	r.cs.mu.Lock()
+	client = r.cs.client
+	r.cs.mu.Unlock()
+
+	session, err = client.NewSession()
+	if err != nil {
+		release()
+		return nil, errors.Wrap(err, "unable to create new session after reconnect")
+	}
+
+	return &remoteSession{Session: session, release: release}, nil
+}
+
+// isDisconnect reports whether err looks like the result of a dropped TCP
+// connection, as opposed to some other session-level failure.
+func isDisconnect(err error) bool {
+	if err == nil {
+		return false
+	}
+	if stderrors.Is(err, io.EOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return stderrors.As(err, &opErr)
+}
+
+// redial tears down the current ssh.Client and sftp clients and establishes a new
+// connection, retrying with backoff according to r.reconnect.
+func (r Remote) redial() error {
+
+	r.cs.mu.Lock()
+	defer r.cs.mu.Unlock()
+
+	backoff := r.reconnect.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectPolicy.InitialBackoff
+	}
+	maxbackoff := r.reconnect.MaxBackoff
+	if maxbackoff <= 0 {
+		maxbackoff = DefaultReconnectPolicy.MaxBackoff
+	}
+
+	var err error
+	for attempt := 1; r.reconnect.MaxAttempts == 0 || attempt <= r.reconnect.MaxAttempts; attempt++ {
+
+		var client *ssh.Client
+		client, err = r.dial()
+		if err == nil {
+			r.cs.client.Close()
+			for user, c := range r.cs.sftp {
+				c.Close()
+				delete(r.cs.sftp, user)
+			}
+			r.cs.client = client
+			return nil
+		}
+
+		select {
+		case <-r.cs.stop:
+			return errors.Wrap(err, "remote was closed during reconnect")
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxbackoff {
+			backoff = maxbackoff
+		}
+	}
+
+	return errors.Wrapf(err, "unable to reconnect to %s", r.addr)
+}
+
+// keepaliveLoop periodically pings the SSH server to detect a silently dropped
+// connection, redialing if a ping fails. It runs until Close is called.
+func (r Remote) keepaliveLoop() {
+	if r.keepalive <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.cs.stop:
+			return
+		case <-ticker.C:
+			r.cs.mu.Lock()
+			client := r.cs.client
+			r.cs.mu.Unlock()
+
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				r.redial()
+			}
+		}
+	}
+}
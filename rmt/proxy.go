@@ -0,0 +1,146 @@
+package rmt
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewVia returns a new Remote reached by dialing addr through bastion, equivalent to
+// OpenSSH's ProxyJump. bastion's own connection is used to reach addr, and the
+// resulting Remote supports the same sftp, keepalive and reconnect behaviour as one
+// connected to directly.
+//
+// bastion is shared, not adopted: it keeps working as a Remote in its own right (and
+// may be passed into any number of other NewVia/NewChain calls too), and is only
+// actually disconnected once every Remote sharing it - including bastion itself -
+// has had Close called on it. See Remote.Close.
+func NewVia(bastion *Remote, addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths []ssh.AuthMethod, opts ...Option) (Remote, error) {
+	return NewChain([]*Remote{bastion}, addr, user, sudopass, hostkeycallback, auths, opts...)
+}
+
+// NewChain returns a new Remote reached by dialing addr through a chain of bastions,
+// each reached via the one before it, equivalent to a multi-hop OpenSSH ProxyJump.
+// bastions[0] is reached over its own existing connection, bastions[1] is dialed
+// fresh via bastions[0], bastions[2] via bastions[1], and so on, with addr finally
+// dialed via the last bastion in the chain. An empty chain behaves like New.
+func NewChain(bastions []*Remote, addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths []ssh.AuthMethod, opts ...Option) (Remote, error) {
+
+	if len(bastions) == 0 {
+		return New(addr, user, sudopass, hostkeycallback, auths, opts...)
+	}
+
+	r := Remote{
+		addr:       addr,
+		connuser:   user,
+		sudopass:   sudopass,
+		activeuser: user,
+		cc: ssh.ClientConfig{
+			User:            user,
+			Auth:            auths,
+			HostKeyCallback: hostkeycallback,
+		},
+		cs:        &connState{sftp: map[string]*sftp.Client{}, stop: make(chan struct{}), refs: 1},
+		sessions:  make(chan struct{}, defaultMaxSessions),
+		keepalive: defaultKeepalive,
+		reconnect: DefaultReconnectPolicy,
+		via:       bastions,
+	}
+
+	// Every bastion is shared - it keeps working as a target in its own right, and
+	// may be reused by other chains too - so bump its refcount rather than letting
+	// this chain's Close tear it down outright; see release.
+	for _, b := range bastions {
+		b.cs.mu.Lock()
+		b.cs.refs++
+		b.cs.mu.Unlock()
+	}
+
+	r.dial = func() (*ssh.Client, error) {
+		client, hops, err := dialChain(bastions, addr, &r.cc)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range r.cs.hops {
+			h.Close()
+		}
+		r.cs.hops = hops
+		return client, nil
+	}
+
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	var err error
+	r.cs.client, err = r.dial()
+	if err != nil {
+		return r, err
+	}
+
+	if err := r.probe(); err != nil {
+		return r, err
+	}
+
+	go r.keepaliveLoop()
+
+	return r, nil
+}
+
+// dialChain walks bastions in order - starting from bastions[0]'s own already-
+// established connection, dialing bastions[1] via bastions[0], bastions[2] via
+// bastions[1], and so on - before finally dialing addr via the last bastion in the
+// chain. It returns the final *ssh.Client together with the intermediate ones opened
+// along the way, so the caller can close them once the chain is torn down.
+func dialChain(bastions []*Remote, addr string, cc *ssh.ClientConfig) (*ssh.Client, []*ssh.Client, error) {
+
+	bastions[0].cs.mu.Lock()
+	client := bastions[0].cs.client
+	bastions[0].cs.mu.Unlock()
+	via := bastions[0].addr
+
+	hops := make([]*ssh.Client, 0, len(bastions)-1)
+	closeHops := func() {
+		for i := len(hops) - 1; i >= 0; i-- {
+			hops[i].Close()
+		}
+	}
+
+	for _, b := range bastions[1:] {
+		next, err := dialVia(client, via, b.addr, &b.cc)
+		if err != nil {
+			closeHops()
+			return nil, nil, err
+		}
+		hops = append(hops, next)
+		client = next
+		via = b.addr
+	}
+
+	final, err := dialVia(client, via, addr, cc)
+	if err != nil {
+		closeHops()
+		return nil, nil, err
+	}
+
+	return final, hops, nil
+}
+
+// dialVia opens a net.Conn to addr over client's connection and wraps it in its own
+// *ssh.Client using cc, the same way ssh -J chains a jump host onto the next hop.
+// via is the address client is connected to, used only to annotate errors.
+func dialVia(client *ssh.Client, via string, addr string, cc *ssh.ClientConfig) (*ssh.Client, error) {
+
+	conn, err := client.Dial("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to reach %s via %s", addr, via)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cc)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "unable to establish ssh connection to %s via %s", addr, via)
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -6,9 +6,9 @@ import (
 	"io"
 	"net"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/krilor/gossh"
 	"github.com/krilor/gossh/rmt/suftp"
@@ -24,9 +24,8 @@ import (
 // Remote represents a Remote target, connected to over SSH
 type Remote struct {
 	addr string
+	cc   ssh.ClientConfig
 
-	// auth
-	conn     *ssh.Client
 	connuser string
 	// sudopass is connusers sudo password
 	sudopass string
@@ -34,66 +33,152 @@ type Remote struct {
 	// the user currently operating as
 	activeuser string
 
-	// sftp holds all sftp connections. key is username. Pointer?
-	sftp map[string]*sftp.Client
+	// cs holds the live ssh/sftp connections. It is shared (via pointer) by every
+	// copy of this Remote, e.g. ones returned by As, so that a reconnect performed
+	// by one copy is visible to all of them.
+	cs *connState
+
+	// sessions bounds the number of concurrent sessions held open against this
+	// Remote. nil means unbounded.
+	sessions chan struct{}
+
+	// keepalive is the interval at which keepalive requests are sent. 0 disables it.
+	keepalive time.Duration
+
+	// reconnect controls the backoff used when redialing a dropped connection.
+	reconnect ReconnectPolicy
+
+	// dial establishes a fresh *ssh.Client to addr, directly for a Remote created by
+	// New, or through the bastion chain for one created by NewVia/NewChain. It is
+	// used both for the initial connection and by redial.
+	dial func() (*ssh.Client, error)
+
+	// via holds the chain of bastions this Remote was reached through, if any, so
+	// that Close can tear the whole chain down.
+	via []*Remote
+
+	// caps holds the sudo capabilities of connuser, as discovered by probe in New or
+	// NewChain.
+	caps Capabilities
 }
 
-// New returns a new Remote target from connection details
-func New(addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths ...ssh.AuthMethod) (Remote, error) {
+// New returns a new Remote target from connection details. Options configure
+// connection management behaviour such as keepalives and reconnect backoff; see
+// WithKeepalive, WithMaxSessions and WithReconnectPolicy.
+func New(addr string, user string, sudopass string, hostkeycallback ssh.HostKeyCallback, auths []ssh.AuthMethod, opts ...Option) (Remote, error) {
 
 	r := Remote{
 		addr:       addr,
 		connuser:   user,
 		sudopass:   sudopass,
 		activeuser: user,
-		sftp:       map[string]*sftp.Client{},
+		cc: ssh.ClientConfig{
+			User:            user,
+			Auth:            auths,
+			HostKeyCallback: hostkeycallback,
+		},
+		cs:        &connState{sftp: map[string]*sftp.Client{}, stop: make(chan struct{}), refs: 1},
+		sessions:  make(chan struct{}, defaultMaxSessions),
+		keepalive: defaultKeepalive,
+		reconnect: DefaultReconnectPolicy,
 	}
 
-	cc := ssh.ClientConfig{
-		User:            user,
-		Auth:            auths,
-		HostKeyCallback: hostkeycallback,
+	r.dial = func() (*ssh.Client, error) {
+		return ssh.Dial("tcp", addr, &r.cc)
+	}
+
+	for _, opt := range opts {
+		opt(&r)
 	}
 
 	var err error
-	r.conn, err = ssh.Dial("tcp", addr, &cc)
+	r.cs.client, err = r.dial()
 	if err != nil {
 		return r, errors.Wrapf(err, "unable to establish ssh connection to %s", addr)
 	}
 
+	if err := r.probe(); err != nil {
+		return r, err
+	}
+
+	go r.keepaliveLoop()
+
 	return r, nil
 
 }
 
-// Close closes all underlying connections
+// Close releases this Remote's reference to its underlying connection. When that was
+// the last reference, it stops the keepalive loop and closes the connection, and if
+// the Remote was created by NewVia or NewChain, also releases its chain of bastions,
+// in reverse order.
+//
+// A bastion passed into NewVia/NewChain is shared - every other Remote dialed
+// through it keeps its own reference - so Close only actually tears down a
+// connection once every Remote sharing it (the one returned by New/NewVia/NewChain,
+// and every chain later dialed through it as a bastion) has released it. Closing one
+// target never pulls a bastion out from under another target still using it.
 func (r Remote) Close() error {
-	for _, c := range r.sftp {
+	return r.release()
+}
+
+// release decrements r's reference count and tears down its underlying connection,
+// sftp clients, chain hops and bastions only once the count reaches zero.
+func (r Remote) release() error {
+	r.cs.mu.Lock()
+	r.cs.refs--
+	shouldClose := r.cs.refs <= 0 && !r.cs.closed
+	if shouldClose {
+		r.cs.closed = true
+		close(r.cs.stop)
+	}
+	r.cs.mu.Unlock()
+
+	if !shouldClose {
+		return nil
+	}
+
+	var err error
+	for _, c := range r.cs.sftp {
 		c.Close()
 	}
+	err = r.cs.client.Close()
+	for i := len(r.cs.hops) - 1; i >= 0; i-- {
+		if cerr := r.cs.hops[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	for i := len(r.via) - 1; i >= 0; i-- {
+		if cerr := r.via[i].release(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
 
-	return r.conn.Close()
+	return err
 }
 
 // sftpClient returns a sftp client for r.activeuser
 // if client does not exist, it will be created
 func (r Remote) sftpClient() (*sftp.Client, error) {
-	var c *sftp.Client
-	var err error
-	var ok bool
-	c, ok = r.sftp[r.activeuser]
-	if ok {
+	r.cs.mu.Lock()
+	defer r.cs.mu.Unlock()
+
+	if c, ok := r.cs.sftp[r.activeuser]; ok {
 		return c, nil
 	}
+
 	// need to create a new connection
+	var c *sftp.Client
+	var err error
 	if r.sudo() {
-		c, err = suftp.NewSudoClient(r.conn, r.activeuser, r.sudopass)
+		c, err = suftp.NewSudoClient(r.cs.client, r.activeuser, r.sudopass)
 	} else {
-		c, err = sftp.NewClient(r.conn)
+		c, err = sftp.NewClient(r.cs.client)
 	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not start sftp connection for %s", r.activeuser)
 	}
-	r.sftp[r.activeuser] = c
+	r.cs.sftp[r.activeuser] = c
 	return c, nil
 }
 
@@ -119,45 +204,138 @@ func (r Remote) Mkdir(path string) error {
 	return sftp.Mkdir(path)
 }
 
-// Put puts the contents of a Reader on a path on the Remote machine
-//
-// Inspiration:
-// https://github.com/laher/scp-go/blob/master/scp/toRemote.go
-// https://gist.github.com/jedy/3357393
+// put puts the contents of a Reader on a path on the Remote machine, over sftp.
 //
-// SCP notes:
-// https://web.archive.org/web/20170215184048/https://blogs.oracle.com/janp/entry/how_the_scp_protocol_works
-// https://en.wikipedia.org/wiki/Secure_copy#cite_note-Pechanec-2
+// The content is written to a temporary file next to path and only chmod'd and
+// renamed into place once fully received, so a failed or interrupted transfer never
+// leaves a partial file at path.
 func (r *Remote) put(content io.Reader, size int64, path string, mode uint32) error {
 
-	// consider using github.com/pkg/sftp
-
-	session, err := r.conn.NewSession()
+	c, err := r.sftpClient()
 	if err != nil {
-		return errors.Wrap(err, "failed to create scp session")
+		return err
 	}
-	defer session.Close()
 
-	go func() {
-		w, _ := session.StdinPipe()
-		defer w.Close()
+	tmp := fmt.Sprintf("%s.gossh-%d", path, os.Getpid())
 
-		// header message has the format C<mode> <size> <filename>
-		fmt.Fprintf(w, "C%04o %d %s\n", mode, size, filepath.Base(path))
+	f, err := c.Create(tmp)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", tmp)
+	}
 
-		io.Copy(w, content)
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		c.Remove(tmp)
+		return errors.Wrapf(err, "unable to write content to %s", tmp)
+	}
 
-		// transfer end with \x00
-		fmt.Fprint(w, "\x00")
-	}()
+	if err := f.Close(); err != nil {
+		c.Remove(tmp)
+		return errors.Wrapf(err, "unable to close %s", tmp)
+	}
 
-	if b, err := session.CombinedOutput(fmt.Sprintf("/usr/bin/scp -tr %s", path)); err != nil {
-		return errors.Wrapf(err, "unable to copy content: %s", string(b))
+	if err := c.Chmod(tmp, os.FileMode(mode)); err != nil {
+		c.Remove(tmp)
+		return errors.Wrapf(err, "unable to chmod %s", tmp)
+	}
+
+	if err := c.PosixRename(tmp, path); err != nil {
+		c.Remove(tmp)
+		return errors.Wrapf(err, "unable to move %s into place at %s", tmp, path)
 	}
 
 	return nil
 }
 
+// Open opens the named file on the Remote for reading.
+func (r Remote) Open(path string) (*sftp.File, error) {
+	c, err := r.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open %s", path)
+	}
+	return f, nil
+}
+
+// Create creates or truncates the named file on the Remote for writing.
+func (r Remote) Create(path string) (*sftp.File, error) {
+	c, err := r.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create %s", path)
+	}
+	return f, nil
+}
+
+// Append opens the named file on the Remote for writing, positioned at the end of
+// the file. The file is created if it does not already exist.
+func (r Remote) Append(path string) (*sftp.File, error) {
+	c, err := r.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open %s for append", path)
+	}
+	return f, nil
+}
+
+// Stat returns file info for path on the Remote.
+func (r Remote) Stat(path string) (os.FileInfo, error) {
+	c, err := r.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	fi, err := c.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to stat %s", path)
+	}
+	return fi, nil
+}
+
+// Remove removes the named file or empty directory on the Remote.
+func (r Remote) Remove(path string) error {
+	c, err := r.sftpClient()
+	if err != nil {
+		return err
+	}
+	return c.Remove(path)
+}
+
+// Chmod changes the mode of the named file on the Remote.
+func (r Remote) Chmod(path string, mode os.FileMode) error {
+	c, err := r.sftpClient()
+	if err != nil {
+		return err
+	}
+	return c.Chmod(path, mode)
+}
+
+// Chown changes the numeric uid and gid of the named file on the Remote.
+func (r Remote) Chown(path string, uid, gid int) error {
+	c, err := r.sftpClient()
+	if err != nil {
+		return err
+	}
+	return c.Chown(path, uid, gid)
+}
+
+// Symlink creates newname as a symbolic link to oldname on the Remote.
+func (r Remote) Symlink(oldname, newname string) error {
+	c, err := r.sftpClient()
+	if err != nil {
+		return err
+	}
+	return c.Symlink(oldname, newname)
+}
+
 // User returns the currently active user
 func (r Remote) User() string {
 	return r.activeuser
@@ -170,7 +348,7 @@ func (r *Remote) String() string {
 // run runs cmd on Remote
 func (r *Remote) run(cmd string, stdin string, sudo bool, user string) (gossh.Response, error) {
 
-	session, err := r.conn.NewSession()
+	session, err := r.newSession()
 	resp := gossh.Response{}
 
 	if err != nil {
@@ -186,11 +364,26 @@ func (r *Remote) run(cmd string, stdin string, sudo bool, user string) (gossh.Re
 
 	// TODO - consider using session.Shell - http://networkbit.ch/golang-ssh-client/#multiple_commands
 	if sudo {
-		session.Stdin = strings.NewReader(r.sudopass + "\n" + stdin + "\n")
+		if !r.caps.Root && !r.caps.NopasswdSudo && !r.caps.PasswordSudo {
+			if r.sudopass == "" {
+				return resp, ErrSudoPasswordRequired
+			}
+			return resp, ErrSudoUnavailable
+		}
+
 		if user == "" || user == "-" {
 			user = "root"
 		}
-		sudocmd := fmt.Sprintf(`sudo -k -S -u %s bash -c "%s"`, user, cmd)
+
+		var sudocmd string
+		if r.caps.Root || r.caps.NopasswdSudo {
+			// sudo won't prompt for a password, so there's nothing for scrubStd to clean up.
+			session.Stdin = strings.NewReader(stdin + "\n")
+			sudocmd = fmt.Sprintf(`sudo -n -u %s bash -c "%s"`, user, cmd)
+		} else {
+			session.Stdin = strings.NewReader(r.sudopass + "\n" + stdin + "\n")
+			sudocmd = fmt.Sprintf(`sudo -k -S -u %s bash -c "%s"`, user, cmd)
+		}
 		err = session.Run(sudocmd)
 
 	} else {
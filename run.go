@@ -0,0 +1,158 @@
+package gossh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Differ is implemented by Rules that can describe what Ensure would change without
+// actually applying it. It is consulted by Inventory.Apply when run with WithDiff.
+type Differ interface {
+	Diff(Target) (string, error)
+}
+
+// RunOption configures an Inventory.Apply run.
+type RunOption func(*runConfig)
+
+type runConfig struct {
+	concurrency int
+	check       bool
+	diff        bool
+}
+
+// WithConcurrency bounds how many hosts are applied to at once. The default, 0, applies
+// to every host in the inventory concurrently.
+func WithConcurrency(n int) RunOption {
+	return func(c *runConfig) {
+		c.concurrency = n
+	}
+}
+
+// WithCheck makes Apply a dry run: only a rule's Check is invoked, Ensure never runs.
+func WithCheck() RunOption {
+	return func(c *runConfig) {
+		c.check = true
+	}
+}
+
+// WithDiff makes Apply record a diff alongside each host's status, for rules that
+// implement Differ. It has no effect on rules that don't.
+func WithDiff() RunOption {
+	return func(c *runConfig) {
+		c.diff = true
+	}
+}
+
+// HostResult is the outcome of applying a rule to a single host.
+type HostResult struct {
+	Host       string `json:"host"`
+	Rule       string `json:"rule"`
+	Status     Status `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Diff       string `json:"diff,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// RunReport is the aggregated outcome of an Inventory.Apply run.
+type RunReport struct {
+	Results []HostResult
+}
+
+// Text renders the report as human-readable lines: one line per host, followed by
+// that host's diff, if one was recorded.
+func (rr RunReport) Text() string {
+	var b strings.Builder
+	for _, res := range rr.Results {
+		fmt.Fprintf(&b, "%-30s %-20s %-12v %6dms", res.Host, res.Rule, res.Status, res.DurationMS)
+		if res.Error != "" {
+			fmt.Fprintf(&b, "  error: %s", res.Error)
+		}
+		b.WriteString("\n")
+		if res.Diff != "" {
+			fmt.Fprintf(&b, "%s\n", res.Diff)
+		}
+	}
+	return b.String()
+}
+
+// WriteJSON writes the report to w as newline-delimited JSON events, one per host,
+// suitable for piping into log aggregators.
+func (rr RunReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, res := range rr.Results {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply runs rule against every host in the inventory concurrently (bounded by
+// WithConcurrency, unbounded by default), aggregating each host's outcome into a
+// RunReport. WithCheck makes it a dry run that never invokes Ensure; WithDiff
+// additionally records a diff for rules that implement Differ.
+func (inv Inventory) Apply(name string, rule Rule, opts ...RunOption) RunReport {
+
+	cfg := runConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var sem chan struct{}
+	if cfg.concurrency > 0 {
+		sem = make(chan struct{}, cfg.concurrency)
+	}
+
+	results := make([]HostResult, len(inv))
+	var wg sync.WaitGroup
+
+	for i, h := range inv {
+		wg.Add(1)
+		go func(i int, h *Host) {
+			defer wg.Done()
+			if sem != nil {
+				sem <- struct{}{}
+				defer func() { <-sem }()
+			}
+			results[i] = applyToHost(h, name, rule, cfg)
+		}(i, h)
+	}
+
+	wg.Wait()
+
+	return RunReport{Results: results}
+}
+
+// applyToHost runs rule against a single host according to cfg, timing the result
+// and recording a diff when asked for one.
+func applyToHost(h *Host, name string, rule Rule, cfg runConfig) HostResult {
+
+	start := time.Now()
+	res := HostResult{Host: h.Name, Rule: name}
+
+	var status Status
+	var err error
+	if cfg.check {
+		status, err = rule.Check(h)
+	} else {
+		status, err = h.Apply(name, rule)
+	}
+
+	if cfg.diff {
+		if d, ok := rule.(Differ); ok {
+			res.Diff, _ = d.Diff(h)
+		}
+	}
+
+	res.Status = status
+	res.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res
+}
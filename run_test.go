@@ -0,0 +1,59 @@
+package gossh
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunReportText(t *testing.T) {
+
+	rr := RunReport{Results: []HostResult{
+		{Host: "web1", Rule: "pkg.Installed", DurationMS: 120},
+		{Host: "web2", Rule: "pkg.Installed", DurationMS: 45, Error: "connection refused"},
+		{Host: "web3", Rule: "pkg.Installed", DurationMS: 12, Diff: "-old\n+new"},
+	}}
+
+	got := rr.Text()
+
+	for _, want := range []string{"web1", "web2", "web3", "connection refused", "-old\n+new"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Text() = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestRunReportWriteJSON(t *testing.T) {
+
+	rr := RunReport{Results: []HostResult{
+		{Host: "web1", Rule: "pkg.Installed", DurationMS: 120},
+		{Host: "web2", Rule: "pkg.Installed", DurationMS: 45, Error: "connection refused"},
+	}}
+
+	var buf bytes.Buffer
+	if err := rr.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() returned error: %v", err)
+	}
+
+	dec := json.NewDecoder(&buf)
+
+	var got []HostResult
+	for dec.More() {
+		var res HostResult
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("decoding emitted JSON: %v", err)
+		}
+		got = append(got, res)
+	}
+
+	if len(got) != len(rr.Results) {
+		t.Fatalf("got %d JSON lines, want %d", len(got), len(rr.Results))
+	}
+
+	for i, res := range got {
+		if res.Host != rr.Results[i].Host || res.Error != rr.Results[i].Error {
+			t.Errorf("line %d: got %+v, want %+v", i, res, rr.Results[i])
+		}
+	}
+}